@@ -0,0 +1,293 @@
+package tuf
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/flynn/go-tuf/data"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyEnvelopeMagic   = "tuf-encrypted-key-v1"
+	scryptN            = 32768
+	scryptR            = 8
+	scryptP            = 1
+	scryptKeyLen       = 32
+	secretboxNonceSize = 24
+)
+
+// PassphraseFunc returns the passphrase to use when encrypting or
+// decrypting the keys for role. When confirm is true (saving a new key or
+// changing a passphrase), implementations should ask the user to type the
+// passphrase twice and return an error if the two don't match.
+type PassphraseFunc func(role string, confirm bool) ([]byte, error)
+
+// keyEnvelope is the on-disk encrypted representation of a *data.Key,
+// written in place of the plaintext JSON fileSystemStore.SaveKey produces.
+type keyEnvelope struct {
+	Magic      string `json:"magic"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// isEncryptedKey reports whether raw is a keyEnvelope rather than a
+// plaintext *data.Key, without fully decoding it.
+func isEncryptedKey(raw []byte) bool {
+	var probe struct {
+		Magic string `json:"magic"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return false
+	}
+	return probe.Magic == keyEnvelopeMagic
+}
+
+func sealKey(key *data.Key, passphrase []byte) (*keyEnvelope, error) {
+	plaintext, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kek, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [secretboxNonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	var kekArr [scryptKeyLen]byte
+	copy(kekArr[:], kek)
+	return &keyEnvelope{
+		Magic:      keyEnvelopeMagic,
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       salt,
+		Nonce:      nonce[:],
+		Ciphertext: secretbox.Seal(nil, plaintext, &nonce, &kekArr),
+	}, nil
+}
+
+func openKey(env *keyEnvelope, passphrase []byte) (*data.Key, error) {
+	kek, err := scrypt.Key(passphrase, env.Salt, env.N, env.R, env.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [secretboxNonceSize]byte
+	copy(nonce[:], env.Nonce)
+	var kekArr [scryptKeyLen]byte
+	copy(kekArr[:], kek)
+	plaintext, ok := secretbox.Open(nil, env.Ciphertext, &nonce, &kekArr)
+	if !ok {
+		return nil, errors.New("tuf: incorrect passphrase or corrupt key file")
+	}
+	key := &data.Key{}
+	if err := json.Unmarshal(plaintext, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// encryptedFileSystemStore is a fileSystemStore whose GetKeys/SaveKey wrap
+// each private key in a passphrase-encrypted envelope instead of writing
+// it as plaintext JSON. Every other LocalStore method is inherited
+// unchanged from fileSystemStore.
+type encryptedFileSystemStore struct {
+	*fileSystemStore
+	passphrase PassphraseFunc
+
+	mu     sync.Mutex
+	cached map[string][]byte
+}
+
+// EncryptedFileSystemStore is like FileSystemStore, except private keys
+// under dir/keys are stored wrapped in a passphrase-encrypted envelope
+// (scrypt-derived KEK + NaCl secretbox) rather than as plaintext JSON.
+// passphrase is called to obtain the passphrase for a role the first time
+// GetKeys needs it, and the result is cached for the lifetime of the store
+// unless it fails to decrypt a key, in which case the next call prompts
+// again. SaveKey, MigrateKeys and ChangePassphrase always prompt (with
+// confirm set) and cache the new result.
+func EncryptedFileSystemStore(dir string, passphrase PassphraseFunc, opts ...FileSystemStoreOption) LocalStore {
+	return &encryptedFileSystemStore{
+		fileSystemStore: FileSystemStore(dir, opts...).(*fileSystemStore),
+		passphrase:      passphrase,
+		cached:          make(map[string][]byte),
+	}
+}
+
+// passphraseFor returns the cached passphrase for role, prompting via the
+// store's PassphraseFunc if there isn't one yet or confirm is true. The
+// returned passphrase is cached right away; a caller that then fails to
+// open a key with it evicts it via forgetPassphrase, so a mistyped
+// passphrase isn't silently reused to fail every subsequent call.
+func (e *encryptedFileSystemStore) passphraseFor(role string, confirm bool) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if p, ok := e.cached[role]; ok && !confirm {
+		return p, nil
+	}
+	p, err := e.passphrase(role, confirm)
+	if err != nil {
+		return nil, err
+	}
+	e.cached[role] = p
+	return p, nil
+}
+
+// forgetPassphrase evicts role's cached passphrase so the next
+// passphraseFor call prompts again, instead of repeating a value that's
+// already known to be wrong.
+func (e *encryptedFileSystemStore) forgetPassphrase(role string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.cached, role)
+}
+
+func (e *encryptedFileSystemStore) keysDir() string {
+	return filepath.Join(e.dir, "keys")
+}
+
+func (e *encryptedFileSystemStore) GetKeys(role string) ([]*data.Key, error) {
+	files, err := ioutil.ReadDir(e.keysDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []*data.Key
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), role) {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(e.keysDir(), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !isEncryptedKey(raw) {
+			key := &data.Key{}
+			if err := json.Unmarshal(raw, key); err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			continue
+		}
+		env := &keyEnvelope{}
+		if err := json.Unmarshal(raw, env); err != nil {
+			return nil, err
+		}
+		passphrase, err := e.passphraseFor(role, false)
+		if err != nil {
+			return nil, err
+		}
+		key, err := openKey(env, passphrase)
+		if err != nil {
+			e.forgetPassphrase(role)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (e *encryptedFileSystemStore) SaveKey(role string, key *data.Key) error {
+	if err := e.createDirs(); err != nil {
+		return err
+	}
+	passphrase, err := e.passphraseFor(role, true)
+	if err != nil {
+		return err
+	}
+	return e.writeEnvelope(role, key, passphrase)
+}
+
+func (e *encryptedFileSystemStore) writeEnvelope(role string, key *data.Key, passphrase []byte) error {
+	env, err := sealKey(key, passphrase)
+	if err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(e.keysDir(), role+"-"+key.ID()+".json")
+	return ioutil.WriteFile(path, append(raw, '\n'), 0600)
+}
+
+// MigrateKeys re-wraps every plaintext key file belonging to role in an
+// encrypted envelope, in place. Files that are already encrypted are left
+// untouched.
+func (e *encryptedFileSystemStore) MigrateKeys(role string) error {
+	files, err := ioutil.ReadDir(e.keysDir())
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), role) {
+			continue
+		}
+		path := filepath.Join(e.keysDir(), file.Name())
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if isEncryptedKey(raw) {
+			continue
+		}
+		key := &data.Key{}
+		if err := json.Unmarshal(raw, key); err != nil {
+			return err
+		}
+		if passphrase == nil {
+			if passphrase, err = e.passphraseFor(role, true); err != nil {
+				return err
+			}
+		}
+		if err := e.writeEnvelope(role, key, passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangePassphrase re-encrypts every key belonging to role under a freshly
+// prompted passphrase, discarding whatever passphrase was cached for it.
+func (e *encryptedFileSystemStore) ChangePassphrase(role string) error {
+	keys, err := e.GetKeys(role)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	delete(e.cached, role)
+	e.mu.Unlock()
+	passphrase, err := e.passphraseFor(role, true)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := e.writeEnvelope(role, key, passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package tuf
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+func TestSealOpenKeyRoundTrip(t *testing.T) {
+	key := &data.Key{}
+	env, err := sealKey(key, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Magic != keyEnvelopeMagic {
+		t.Fatalf("unexpected envelope magic: %q", env.Magic)
+	}
+
+	if _, err := openKey(env, []byte("wrong passphrase")); err == nil {
+		t.Fatal("expected an error opening the envelope with the wrong passphrase")
+	}
+
+	if _, err := openKey(env, []byte("correct horse battery staple")); err != nil {
+		t.Fatalf("expected the envelope to open with the correct passphrase: %v", err)
+	}
+}
+
+// TestEncryptedFileSystemStoreGetKeysRetriesAfterWrongPassphrase is a
+// regression test for a cache that used to remember a mistyped passphrase
+// forever: once GetKeys failed to decrypt with it, every later call for the
+// same role failed too, even if the caller supplied the right passphrase
+// the next time.
+func TestEncryptedFileSystemStoreGetKeysRetriesAfterWrongPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-encrypted-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const role = "root"
+	correct := []byte("correct horse battery staple")
+
+	seed := EncryptedFileSystemStore(dir, func(string, bool) ([]byte, error) {
+		return correct, nil
+	}).(*encryptedFileSystemStore)
+	if err := seed.SaveKey(role, &data.Key{}); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	store := EncryptedFileSystemStore(dir, func(r string, confirm bool) ([]byte, error) {
+		attempts++
+		if attempts == 1 {
+			return []byte("wrong passphrase"), nil
+		}
+		return correct, nil
+	})
+
+	if _, err := store.GetKeys(role); err == nil {
+		t.Fatal("expected GetKeys to fail with the wrong passphrase")
+	}
+
+	keys, err := store.GetKeys(role)
+	if err != nil {
+		t.Fatalf("GetKeys should have re-prompted instead of reusing the wrong cached passphrase: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(keys))
+	}
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 passphrase prompts, got %d", attempts)
+	}
+}
+
+func TestEncryptedFileSystemStoreMigrateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-encrypted-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const role = "root"
+	if err := FileSystemStore(dir).SaveKey(role, &data.Key{}); err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := []byte("correct horse battery staple")
+	es := EncryptedFileSystemStore(dir, func(string, bool) ([]byte, error) {
+		return passphrase, nil
+	}).(*encryptedFileSystemStore)
+
+	if err := es.MigrateKeys(role); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(es.keysDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected exactly one key file, got %d", len(files))
+	}
+	raw, err := ioutil.ReadFile(es.keysDir() + "/" + files[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedKey(raw) {
+		t.Fatal("expected the migrated key file to be encrypted")
+	}
+
+	keys, err := es.GetKeys(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key after migration, got %d", len(keys))
+	}
+}
@@ -0,0 +1,95 @@
+package tuf
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+func (f *fileSystemStore) blobsDir() string {
+	return filepath.Join(f.stagedDir(), "blobs", "sha256")
+}
+
+func (f *fileSystemStore) blobIndexPath() string {
+	return filepath.Join(f.stagedDir(), "blobs", "index.json")
+}
+
+func (f *fileSystemStore) readBlobIndex() (blobIndex, error) {
+	raw, err := ioutil.ReadFile(f.blobIndexPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return blobIndex{}, nil
+		}
+		return nil, err
+	}
+	idx := blobIndex{}
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (f *fileSystemStore) addToBlobIndex(path, sha256Hex string) error {
+	idx, err := f.readBlobIndex()
+	if err != nil {
+		return err
+	}
+	idx[path] = sha256Hex
+	raw, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.blobIndexPath(), raw, 0644)
+}
+
+// AddTargetStream streams r into a content-addressed blob under
+// staged/blobs/sha256/<hex>, recording path -> hex in the blob index so
+// identical targets staged under different paths share one copy on disk.
+// GetStagedTarget and Commit consult the index transparently.
+func (f *fileSystemStore) AddTargetStream(path string, r io.Reader) (data.Hashes, int64, error) {
+	if err := f.createDirs(); err != nil {
+		return nil, 0, err
+	}
+	if err := os.MkdirAll(f.blobsDir(), 0755); err != nil {
+		return nil, 0, err
+	}
+	tmp, err := ioutil.TempFile(f.blobsDir(), ".tmp-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	sha256Hash := sha256.New()
+	sha512Hash := sha512.New()
+	n, err := io.Copy(io.MultiWriter(tmp, sha256Hash, sha512Hash), r)
+	if err != nil {
+		tmp.Close()
+		return nil, 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	sha256Sum := hex.EncodeToString(sha256Hash.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(f.blobsDir(), sha256Sum)); err != nil {
+		return nil, 0, err
+	}
+	if err := f.addToBlobIndex(path, sha256Sum); err != nil {
+		return nil, 0, err
+	}
+	return data.Hashes{
+		"sha256": data.HexBytes(sha256Hash.Sum(nil)),
+		"sha512": data.HexBytes(sha512Hash.Sum(nil)),
+	}, n, nil
+}
@@ -0,0 +1,315 @@
+package tuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+// commitAtomically builds the next generation of the repository directory
+// in a sibling "repository.new-<txid>/" directory, fsyncs it, and swaps it
+// in with two renames:
+//
+//	repository/         -> repository.old-<txid>/
+//	repository.new-<txid>/ -> repository/
+//
+// A crash at any point before the first rename leaves the existing
+// repository/ untouched; a crash between the renames leaves both
+// directories on disk, which recoverInterruptedCommit resolves on the next
+// FileSystemStore startup by checking which one holds a valid
+// timestamp.json.
+func (f *fileSystemStore) commitAtomically(consistentSnapshot bool, hashes map[string]data.Hashes) error {
+	txid := newTxID()
+	newDir := f.generationDir("new", txid)
+	oldDir := f.generationDir("old", txid)
+
+	if err := f.stageGeneration(newDir, consistentSnapshot, hashes); err != nil {
+		os.RemoveAll(newDir)
+		return err
+	}
+	if err := fsyncTree(newDir); err != nil {
+		os.RemoveAll(newDir)
+		return err
+	}
+
+	hadRepo := true
+	if _, err := os.Stat(f.repoDir()); os.IsNotExist(err) {
+		hadRepo = false
+	}
+	if hadRepo {
+		if err := os.Rename(f.repoDir(), oldDir); err != nil {
+			os.RemoveAll(newDir)
+			return err
+		}
+	}
+	if err := os.Rename(newDir, f.repoDir()); err != nil {
+		if hadRepo {
+			os.Rename(oldDir, f.repoDir())
+		}
+		return err
+	}
+	if err := fsyncPath(f.dir); err != nil {
+		return err
+	}
+	if !hadRepo {
+		return nil
+	}
+	return f.pruneGeneration(oldDir)
+}
+
+// generationDir returns the path of the repository.<kind>-<txid>/
+// directory used while committing, where kind is "new" or "old".
+func (f *fileSystemStore) generationDir(kind, txid string) string {
+	return filepath.Join(f.dir, fmt.Sprintf("repository.%s-%s", kind, txid))
+}
+
+// stageGeneration copies every staged file into newDir at the repository-
+// relative paths commitCopyPaths would produce, hardlinking from the
+// current repository/ instead of copying wherever the destination is a
+// content-addressed (hashed) target path that's already present there.
+// The staged/blobs tree (content-addressed targets added via
+// AddTargetStream) is handled separately by stageBlobTargets, since its
+// own paths aren't repository paths.
+func (f *fileSystemStore) stageGeneration(newDir string, consistentSnapshot bool, hashes map[string]data.Hashes) error {
+	stagedDir := f.stagedDir()
+	blobsDir := filepath.Join(stagedDir, "blobs")
+	err := filepath.Walk(stagedDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == blobsDir {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(stagedDir, path)
+		if err != nil {
+			return err
+		}
+		for _, dst := range commitCopyPaths(rel, consistentSnapshot, hashes[rel]) {
+			hashed := consistentSnapshot && dst != rel
+			if err := f.linkOrCopy(newDir, dst, path, hashed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.stageBlobTargets(newDir, consistentSnapshot, hashes)
+}
+
+// stageBlobTargets places every target recorded in the blob index into
+// newDir. Multiple target paths sharing the same blob (the whole point of
+// AddTargetStream's dedup) are written once and hardlinked for the rest,
+// so the repository doesn't pay the storage cost of the duplication
+// either.
+func (f *fileSystemStore) stageBlobTargets(newDir string, consistentSnapshot bool, hashes map[string]data.Hashes) error {
+	idx, err := f.readBlobIndex()
+	if err != nil {
+		return err
+	}
+	placed := make(map[string]string) // sha256 hex -> already-written path in newDir
+	for targetPath, sha256Sum := range idx {
+		rel := filepath.Join("targets", targetPath)
+		src := filepath.Join(f.blobsDir(), sha256Sum)
+		for _, dst := range commitCopyPaths(rel, consistentSnapshot, hashes[rel]) {
+			dstAbs := filepath.Join(newDir, dst)
+			if err := os.MkdirAll(filepath.Dir(dstAbs), 0755); err != nil {
+				return err
+			}
+			if already, ok := placed[sha256Sum]; ok {
+				if err := os.Link(already, dstAbs); err == nil {
+					continue
+				}
+			}
+			if err := copyFileSync(src, dstAbs); err != nil {
+				return err
+			}
+			placed[sha256Sum] = dstAbs
+		}
+	}
+	return nil
+}
+
+// linkOrCopy places the staged file at src into newDir at rel. When hashed
+// is true, rel's name is content-addressed, so an existing file of the
+// same name under the current repository/ is known to be byte-identical
+// and can be hardlinked instead of copied.
+func (f *fileSystemStore) linkOrCopy(newDir, rel, src string, hashed bool) error {
+	dst := filepath.Join(newDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if hashed {
+		if err := os.Link(filepath.Join(f.repoDir(), rel), dst); err == nil {
+			return nil
+		}
+	}
+	return copyFileSync(src, dst)
+}
+
+// copyFileSync copies src to dst and fsyncs dst before returning.
+func copyFileSync(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// pruneGeneration removes oldDir, unless the store was configured with
+// KeepGenerations, in which case it keeps the newest keepGenerations
+// repository.old-* directories and removes the rest.
+func (f *fileSystemStore) pruneGeneration(oldDir string) error {
+	if f.keepGenerations <= 0 {
+		return os.RemoveAll(oldDir)
+	}
+	matches, err := filepath.Glob(filepath.Join(f.dir, "repository.old-*"))
+	if err != nil {
+		return err
+	}
+	// txids are a fixed-width, monotonically increasing timestamp, so
+	// lexical order is chronological order.
+	sort.Strings(matches)
+	if len(matches) <= f.keepGenerations {
+		return nil
+	}
+	for _, dir := range matches[:len(matches)-f.keepGenerations] {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverInterruptedCommit looks for repository.new-*/repository.old-*
+// directories left behind by a commit that crashed between the two
+// renames in commitAtomically, and resolves them by checking which
+// directory (if either) holds a valid timestamp.json: a valid new
+// generation is rolled forward, otherwise it's discarded as incomplete; an
+// old generation is only restored if the current repository/ is missing
+// or invalid.
+//
+// A repository.old-<txid>/ directory is ambiguous on its own: it's either
+// crash debris from a commit that got as far as the first rename but not
+// the second, or a generation deliberately retained by KeepGenerations
+// from some earlier, already-completed commit. Only the former has a
+// matching repository.new-<txid>/ with the same txid (the second rename
+// is what makes the new directory disappear on success), so that's the
+// only case this removes; a lone old-<txid> next to a valid repository/
+// is left alone regardless of the current call's keepGenerations, since
+// that option isn't persisted and a different invocation may be the one
+// that asked to keep it.
+func (f *fileSystemStore) recoverInterruptedCommit() {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+	newTxIDs := make(map[string]bool)
+	for _, entry := range entries {
+		if txid, ok := generationTxID(entry.Name(), "new"); ok {
+			newTxIDs[txid] = true
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		dir := filepath.Join(f.dir, name)
+		switch {
+		case isGenerationDir(name, "new"):
+			if hasValidTimestamp(dir) {
+				os.RemoveAll(f.repoDir())
+				os.Rename(dir, f.repoDir())
+			} else {
+				os.RemoveAll(dir)
+			}
+		case isGenerationDir(name, "old"):
+			if !hasValidTimestamp(f.repoDir()) {
+				if hasValidTimestamp(dir) {
+					os.RemoveAll(f.repoDir())
+					os.Rename(dir, f.repoDir())
+				}
+				continue
+			}
+			if txid, _ := generationTxID(name, "old"); newTxIDs[txid] {
+				os.RemoveAll(dir)
+			}
+		}
+	}
+}
+
+func isGenerationDir(name, kind string) bool {
+	_, ok := generationTxID(name, kind)
+	return ok
+}
+
+// generationTxID extracts the txid from a repository.<kind>-<txid>
+// directory name.
+func generationTxID(name, kind string) (string, bool) {
+	prefix := "repository." + kind + "-"
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+func hasValidTimestamp(dir string) bool {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "timestamp.json"))
+	if err != nil {
+		return false
+	}
+	return json.Valid(raw)
+}
+
+// newTxID returns a fixed-width, monotonically increasing identifier used
+// to name the directories involved in a single commit.
+func newTxID() string {
+	return fmt.Sprintf("%020d", time.Now().UnixNano())
+}
+
+// fsyncTree fsyncs every regular file under dir, then dir itself, so a
+// newly staged generation is durable on disk before it's swapped in.
+func fsyncTree(dir string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		return fsyncPath(path)
+	})
+	if err != nil {
+		return err
+	}
+	return fsyncPath(dir)
+}
+
+// fsyncPath opens path (file or directory) and calls Sync on it.
+func fsyncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
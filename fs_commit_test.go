@@ -0,0 +1,146 @@
+package tuf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSystemStoreCommitAtomicSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-commit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileSystemStore(dir).(*fileSystemStore)
+	if err := store.SetMeta("timestamp.json", json.RawMessage(`{"v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "repository", "timestamp.json")); err != nil {
+		t.Fatalf("expected committed file: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "repository.new-*")); len(matches) != 0 {
+		t.Fatalf("leftover staging dir after commit: %v", matches)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "repository.old-*")); len(matches) != 0 {
+		t.Fatalf("previous generation not pruned: %v", matches)
+	}
+}
+
+func TestFileSystemStoreKeepGenerations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-commit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileSystemStore(dir, KeepGenerations(1)).(*fileSystemStore)
+	for i := 0; i < 2; i++ {
+		if err := store.SetMeta("timestamp.json", json.RawMessage(`{"v":1}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Commit(nil, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "repository.old-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one retained generation, got %v", matches)
+	}
+}
+
+// TestFileSystemStoreRecoveryKeepsRetainedGeneration guards against
+// recoverInterruptedCommit deleting a repository.old-* directory that a
+// prior KeepGenerations run deliberately kept, just because a later
+// FileSystemStore call didn't pass the same option.
+func TestFileSystemStoreRecoveryKeepsRetainedGeneration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-commit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := FileSystemStore(dir, KeepGenerations(1)).(*fileSystemStore)
+	for i := 0; i < 2; i++ {
+		if err := store.SetMeta("timestamp.json", json.RawMessage(`{"v":1}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Commit(nil, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "repository.old-*"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected one retained generation before reopen, got %v (err %v)", matches, err)
+	}
+	retained := matches[0]
+
+	// Reopen the store without KeepGenerations, as some other process or
+	// invocation could; recoverInterruptedCommit must not treat the
+	// retained generation as crash debris.
+	FileSystemStore(dir)
+
+	if _, err := os.Stat(retained); err != nil {
+		t.Fatalf("recoverInterruptedCommit deleted a deliberately retained generation: %v", err)
+	}
+}
+
+func TestFileSystemStoreRecoveryRollsForwardValidNewGeneration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-commit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newDir := filepath.Join(dir, "repository.new-00000000000000000001")
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(newDir, "timestamp.json"), []byte(`{"v":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	FileSystemStore(dir)
+
+	if _, err := os.Stat(filepath.Join(dir, "repository", "timestamp.json")); err != nil {
+		t.Fatalf("expected valid new generation to be rolled forward: %v", err)
+	}
+	if _, err := os.Stat(newDir); !os.IsNotExist(err) {
+		t.Fatalf("expected staging dir to be consumed by the roll-forward rename, got err=%v", err)
+	}
+}
+
+func TestFileSystemStoreRecoveryDiscardsInvalidNewGeneration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuf-commit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newDir := filepath.Join(dir, "repository.new-00000000000000000001")
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// No timestamp.json: the staged generation never finished.
+
+	FileSystemStore(dir)
+
+	if _, err := os.Stat(newDir); !os.IsNotExist(err) {
+		t.Fatalf("expected incomplete new generation to be discarded, got err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "repository")); !os.IsNotExist(err) {
+		t.Fatalf("expected no repository/ to have been created from an invalid generation, got err=%v", err)
+	}
+}
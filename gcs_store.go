@@ -0,0 +1,85 @@
+package tuf
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsKV is a remoteKV backed by a Google Cloud Storage bucket, addressed
+// by a gs://bucket/prefix URL.
+type gcsKV struct {
+	bucket *storage.BucketHandle
+}
+
+func newGCSStore(u *url.URL, opts *RemoteStoreOptions) (LocalStore, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &remoteStore{
+		kv:     &gcsKV{bucket: client.Bucket(u.Host)},
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (k *gcsKV) read(name string) ([]byte, error) {
+	r, err := k.bucket.Object(name).NewReader(context.Background())
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, ErrFileNotFound{name}
+		}
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (k *gcsKV) write(name string, r io.Reader) error {
+	w := k.bucket.Object(name).NewWriter(context.Background())
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (k *gcsKV) list(prefix string) ([]string, error) {
+	var names []string
+	it := k.bucket.Objects(context.Background(), &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, strings.TrimPrefix(attrs.Name, prefix+"/"))
+	}
+	return names, nil
+}
+
+// copy uses GCS's server-side object copy, so the bytes themselves are
+// never re-uploaded.
+func (k *gcsKV) copy(src, dst string) error {
+	_, err := k.bucket.Object(dst).CopierFrom(k.bucket.Object(src)).Run(context.Background())
+	return err
+}
+
+func (k *gcsKV) move(src, dst string) error {
+	if err := k.copy(src, dst); err != nil {
+		return err
+	}
+	k.remove(src)
+	return nil
+}
+
+func (k *gcsKV) remove(name string) {
+	k.bucket.Object(name).Delete(context.Background()) // TODO: log / handle error
+}
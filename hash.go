@@ -0,0 +1,71 @@
+package tuf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"io"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+// blobIndex maps a staged target path to the hex-encoded SHA-256 digest of
+// the content-addressed blob AddTargetStream stored it under, so the same
+// bytes staged under many paths (e.g. an identical binary in several
+// version directories) are written to staged/blobs once rather than once
+// per path.
+type blobIndex map[string]string
+
+// hashReader fully reads r, returning its SHA-256/SHA-512 hashes alongside
+// the bytes read and their count. It's for memoryStore.AddTargetStream,
+// which holds blob content in memory anyway; fileSystemStore and
+// remoteStore hash while streaming instead (remoteStore via hashTee), so a
+// large target never has to be buffered in full just to learn its digest.
+func hashReader(r io.Reader) (data.Hashes, []byte, int64, error) {
+	sha256Hash := sha256.New()
+	sha512Hash := sha512.New()
+	buf := &bytes.Buffer{}
+	n, err := io.Copy(io.MultiWriter(buf, sha256Hash, sha512Hash), r)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	hashes := data.Hashes{
+		"sha256": data.HexBytes(sha256Hash.Sum(nil)),
+		"sha512": data.HexBytes(sha512Hash.Sum(nil)),
+	}
+	return hashes, buf.Bytes(), n, nil
+}
+
+// hashTee wraps a reader, accumulating its SHA-256/SHA-512 hashes as it's
+// read. Since a content-addressed blob's destination name depends on its
+// digest, which isn't known until the last byte is read,
+// remoteStore.AddTargetStream uploads through a hashTee into a provisional
+// location and only learns the final hashed name once the upload finishes.
+type hashTee struct {
+	r      io.Reader
+	sha256 hash.Hash
+	sha512 hash.Hash
+	n      int64
+}
+
+func newHashTee(r io.Reader) *hashTee {
+	return &hashTee{r: r, sha256: sha256.New(), sha512: sha512.New()}
+}
+
+func (h *hashTee) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.sha256.Write(p[:n])
+		h.sha512.Write(p[:n])
+		h.n += int64(n)
+	}
+	return n, err
+}
+
+func (h *hashTee) hashes() data.Hashes {
+	return data.Hashes{
+		"sha256": data.HexBytes(h.sha256.Sum(nil)),
+		"sha512": data.HexBytes(h.sha512.Sum(nil)),
+	}
+}
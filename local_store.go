@@ -17,16 +17,26 @@ func MemoryStore(meta map[string]json.RawMessage, files map[string][]byte) Local
 		meta = make(map[string]json.RawMessage)
 	}
 	return &memoryStore{
-		meta:  meta,
-		files: files,
-		keys:  make(map[string][]*data.Key),
+		meta:      meta,
+		files:     files,
+		blobs:     make(map[string][]byte),
+		blobIndex: make(blobIndex),
+		keys:      make(map[string][]*data.Key),
 	}
 }
 
 type memoryStore struct {
 	meta  map[string]json.RawMessage
 	files map[string][]byte
-	keys  map[string][]*data.Key
+
+	// blobs and blobIndex back AddTargetStream: blobs maps a hex SHA-256
+	// digest to content, and blobIndex maps a staged path to the digest
+	// of the blob it was last streamed into, so identical content staged
+	// under multiple paths is only held once.
+	blobs     map[string][]byte
+	blobIndex blobIndex
+
+	keys map[string][]*data.Key
 }
 
 func (m *memoryStore) GetMeta() (map[string]json.RawMessage, error) {
@@ -39,11 +49,61 @@ func (m *memoryStore) SetMeta(name string, meta json.RawMessage) error {
 }
 
 func (m *memoryStore) GetStagedTarget(path string) (io.ReadCloser, error) {
-	data, ok := m.files[path]
-	if !ok {
-		return nil, ErrFileNotFound{path}
+	if sha256Sum, ok := m.blobIndex[path]; ok {
+		return ioutil.NopCloser(bytes.NewReader(m.blobs[sha256Sum])), nil
+	}
+	if data, ok := m.files[path]; ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil, ErrFileNotFound{path}
+}
+
+// AddTargetStream reads r fully, storing it as a content-addressed blob
+// keyed by its SHA-256 digest so that staging the same content under
+// multiple paths (e.g. MemoryStore-backed tests with fixtures shared
+// across targets) only holds one copy in memory.
+func (m *memoryStore) AddTargetStream(path string, r io.Reader) (data.Hashes, int64, error) {
+	hashes, content, n, err := hashReader(r)
+	if err != nil {
+		return nil, 0, err
 	}
-	return ioutil.NopCloser(bytes.NewReader(data)), nil
+	sha256Sum := hashes["sha256"].String()
+	m.blobs[sha256Sum] = content
+	m.blobIndex[path] = sha256Sum
+	return hashes, n, nil
+}
+
+func (m *memoryStore) WalkStagedTargets(patterns []string, fn TargetsWalkFunc) error {
+	for path, f := range m.files {
+		if _, ok := m.blobIndex[path]; ok {
+			// AddTargetStream re-staged this path; that copy is
+			// newer and takes precedence, same as GetStagedTarget.
+			continue
+		}
+		matched, err := matchesAny(patterns, path)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		if err := fn(path, ioutil.NopCloser(bytes.NewReader(f))); err != nil {
+			return err
+		}
+	}
+	for path, sha256Sum := range m.blobIndex {
+		matched, err := matchesAny(patterns, path)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		if err := fn(path, ioutil.NopCloser(bytes.NewReader(m.blobs[sha256Sum]))); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m *memoryStore) Commit(map[string]json.RawMessage, bool, map[string]data.Hashes) error {
@@ -66,12 +126,29 @@ func (m *memoryStore) Clean() error {
 	return nil
 }
 
-func FileSystemStore(dir string) LocalStore {
-	return &fileSystemStore{dir}
+// FileSystemStoreOption configures a FileSystemStore.
+type FileSystemStoreOption func(*fileSystemStore)
+
+// KeepGenerations configures Commit to retain the last n superseded
+// repository/ directories (as repository.old-<txid>/) for manual
+// rollback, rather than removing the previous generation as soon as the
+// new one is in place.
+func KeepGenerations(n int) FileSystemStoreOption {
+	return func(f *fileSystemStore) { f.keepGenerations = n }
+}
+
+func FileSystemStore(dir string, opts ...FileSystemStoreOption) LocalStore {
+	f := &fileSystemStore{dir: dir}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.recoverInterruptedCommit()
+	return f
 }
 
 type fileSystemStore struct {
-	dir string
+	dir             string
+	keepGenerations int
 }
 
 func (f *fileSystemStore) repoDir() string {
@@ -125,7 +202,15 @@ func (f *fileSystemStore) createDirs() error {
 }
 
 func (f *fileSystemStore) GetStagedTarget(path string) (io.ReadCloser, error) {
-	path = filepath.Join(f.stagedDir(), "targets", path)
+	idx, err := f.readBlobIndex()
+	if err != nil {
+		return nil, err
+	}
+	if sha256Sum, ok := idx[path]; ok {
+		path = filepath.Join(f.blobsDir(), sha256Sum)
+	} else {
+		path = filepath.Join(f.stagedDir(), "targets", path)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -136,102 +221,120 @@ func (f *fileSystemStore) GetStagedTarget(path string) (io.ReadCloser, error) {
 	return file, nil
 }
 
-func (f *fileSystemStore) createRepoFile(path string) (*os.File, error) {
-	dst := filepath.Join(f.repoDir(), path)
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return nil, err
-	}
-	return os.Create(dst)
-}
-
-func hashedPaths(path string, hashes data.Hashes) []string {
-	paths := make([]string, 0, len(hashes))
-	for _, hash := range hashes {
-		hashedPath := filepath.Join(filepath.Dir(path), hash.String()+"."+filepath.Base(path))
-		paths = append(paths, hashedPath)
-	}
-	return paths
-}
-
-func (f *fileSystemStore) Commit(meta map[string]json.RawMessage, consistentSnapshot bool, hashes map[string]data.Hashes) error {
-	shouldCopyHashed := func(path string) bool {
-		return consistentSnapshot && path != "timestamp.json"
-	}
-	shouldCopyUnhashed := func(path string) bool {
-		return !consistentSnapshot || path == "root.json" || path == "timestamp.json"
+func (f *fileSystemStore) WalkStagedTargets(patterns []string, fn TargetsWalkFunc) error {
+	idx, err := f.readBlobIndex()
+	if err != nil {
+		return err
 	}
-	copyToRepo := func(path string, info os.FileInfo, err error) error {
+	targetsDir := filepath.Join(f.stagedDir(), "targets")
+	err = filepath.Walk(targetsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
 		if info.IsDir() || !info.Mode().IsRegular() {
 			return nil
 		}
-		rel, err := filepath.Rel(f.stagedDir(), path)
+		rel, err := filepath.Rel(targetsDir, path)
 		if err != nil {
 			return err
 		}
-		var paths []string
-		if shouldCopyHashed(rel) {
-			paths = append(paths, hashedPaths(rel, hashes[rel])...)
-		}
-		if shouldCopyUnhashed(rel) {
-			paths = append(paths, rel)
-		}
-		var files []io.Writer
-		for _, path := range paths {
-			file, err := f.createRepoFile(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			files = append(files, file)
+		rel = filepath.ToSlash(rel)
+		if _, ok := idx[rel]; ok {
+			// AddTargetStream re-staged this path via the blob
+			// index; that copy is newer and takes precedence, the
+			// same way GetStagedTarget prefers it.
+			return nil
 		}
-		staged, err := os.Open(path)
+		matched, err := matchesAny(patterns, rel)
 		if err != nil {
 			return err
 		}
-		defer staged.Close()
-		if _, err = io.Copy(io.MultiWriter(files...), staged); err != nil {
-			return err
+		if !matched {
+			return nil
 		}
-		return nil
-	}
-	isTarget := func(path string) bool {
-		return strings.HasPrefix(path, "targets")
-	}
-	needsRemoval := func(path string) bool {
-		if consistentSnapshot {
-			// strip out the hash
-			name := strings.SplitN(filepath.Base(path), ".", 2)
-			if name[1] == "" {
-				return false
-			}
-			path = filepath.Join(filepath.Dir(path), name[1])
+		file, err := os.Open(path)
+		if err != nil {
+			return err
 		}
-		_, ok := hashes[path]
-		return !ok
+		defer file.Close()
+		return fn(rel, file)
+	})
+	if err != nil {
+		return err
 	}
-	removeFile := func(path string, info os.FileInfo, err error) error {
+	for path, sha256Sum := range idx {
+		matched, err := matchesAny(patterns, path)
 		if err != nil {
 			return err
 		}
-		rel, err := filepath.Rel(f.repoDir(), path)
+		if !matched {
+			continue
+		}
+		file, err := os.Open(filepath.Join(f.blobsDir(), sha256Sum))
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && isTarget(rel) && needsRemoval(rel) {
-			if err := os.Remove(path); err != nil {
-				// TODO: log / handle error
-			}
-			// TODO: remove empty directory
+		if err := fn(path, file); err != nil {
+			file.Close()
+			return err
 		}
-		return nil
+		file.Close()
 	}
-	if err := filepath.Walk(f.stagedDir(), copyToRepo); err != nil {
-		return err
+	return nil
+}
+
+func hashedPaths(path string, hashes data.Hashes) []string {
+	paths := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		hashedPath := filepath.Join(filepath.Dir(path), hash.String()+"."+filepath.Base(path))
+		paths = append(paths, hashedPath)
 	}
-	if err := filepath.Walk(f.repoDir(), removeFile); err != nil {
+	return paths
+}
+
+// commitCopyPaths returns the repository-relative paths that the staged
+// file at rel should be copied to when committing, taking consistent
+// snapshots into account. It is shared by every LocalStore backend so
+// hashed-path naming stays consistent regardless of where the bytes live.
+func commitCopyPaths(rel string, consistentSnapshot bool, hashes data.Hashes) []string {
+	var paths []string
+	if consistentSnapshot && rel != "timestamp.json" {
+		paths = append(paths, hashedPaths(rel, hashes)...)
+	}
+	if !consistentSnapshot || rel == "root.json" || rel == "timestamp.json" {
+		paths = append(paths, rel)
+	}
+	return paths
+}
+
+// commitNeedsRemoval reports whether the repository file at rel is a stale
+// target (i.e. no longer referenced by hashes) and should be deleted as
+// part of Commit. Like commitCopyPaths, it is reused by every backend.
+func commitNeedsRemoval(rel string, consistentSnapshot bool, hashes map[string]data.Hashes) bool {
+	if !strings.HasPrefix(rel, "targets") {
+		return false
+	}
+	if consistentSnapshot {
+		// strip out the hash
+		name := strings.SplitN(filepath.Base(rel), ".", 2)
+		if name[1] == "" {
+			return false
+		}
+		rel = filepath.Join(filepath.Dir(rel), name[1])
+	}
+	_, ok := hashes[rel]
+	return !ok
+}
+
+// Commit materializes the staged meta and targets into the repository
+// directory using a two-phase rename, so a crash mid-commit can never
+// leave repository/ in a half-written state. See commitAtomically for the
+// details.
+func (f *fileSystemStore) Commit(meta map[string]json.RawMessage, consistentSnapshot bool, hashes map[string]data.Hashes) error {
+	if err := f.commitAtomically(consistentSnapshot, hashes); err != nil {
 		return err
 	}
 	return f.Clean()
@@ -0,0 +1,305 @@
+package tuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+// remoteKV is the small set of primitives a networked object/file store
+// needs to provide. remoteStore implements the entire LocalStore contract
+// on top of it, so GetMeta/SetMeta/Commit/Clean/GetKeys/SaveKey/
+// AddTargetStream/WalkStagedTargets are written once instead of once per
+// backend (ftp, sftp, s3, gcs).
+type remoteKV interface {
+	// read returns the contents of the object/file at key, or
+	// ErrFileNotFound if key doesn't exist.
+	read(key string) ([]byte, error)
+	// write creates or overwrites the object/file at key with the
+	// contents of r.
+	write(key string, r io.Reader) error
+	// list returns every key found under prefix (treated as a
+	// directory), with prefix itself stripped and using "/" as the
+	// separator regardless of the host OS.
+	list(prefix string) ([]string, error)
+	// copy duplicates the object/file at src to dst, leaving src in
+	// place. Backends that can do this server-side (S3, GCS) should;
+	// others may fall back to a read followed by a write.
+	copy(src, dst string) error
+	// move relocates the object/file at src to dst; unlike copy, src is
+	// consumed. Used only for provisional-to-final renames where src
+	// and dst never coexist for long, so backends without a true
+	// rename primitive may implement it as copy-then-remove.
+	//
+	// An error here is a genuine failure to place content at dst (and
+	// the caller will treat it as such: the provisional src is removed
+	// and the operation that requested the move fails). A backend whose
+	// rename primitive can fail merely because dst already exists (ftp,
+	// sftp) and wants to treat that as "dst already holds identical
+	// content, since this is only ever used for content-addressed keys"
+	// must absorb that case itself and return nil, not surface it here.
+	move(src, dst string) error
+	// remove deletes the object/file at key if it exists. Errors are
+	// not actionable (the caller is already cleaning up or has
+	// already used the data), so remove reports nothing back.
+	remove(key string)
+}
+
+// remoteStore is a LocalStore backed by a remoteKV, laying out keys the
+// same way FileSystemStore lays out a directory: prefix/staged/...,
+// prefix/repository/... and prefix/keys/....
+type remoteStore struct {
+	kv     remoteKV
+	prefix string
+}
+
+func (r *remoteStore) object(elem ...string) string {
+	return path.Join(append([]string{r.prefix}, elem...)...)
+}
+
+func (r *remoteStore) stagedObject(elem ...string) string {
+	return r.object(append([]string{"staged"}, elem...)...)
+}
+
+func (r *remoteStore) repoObject(elem ...string) string {
+	return r.object(append([]string{"repository"}, elem...)...)
+}
+
+func (r *remoteStore) keysObject(elem ...string) string {
+	return r.object(append([]string{"keys"}, elem...)...)
+}
+
+// blobObject returns the key of the content-addressed blob for a SHA-256
+// digest written by AddTargetStream.
+func (r *remoteStore) blobObject(sha256Sum string) string {
+	return r.stagedObject("blobs", "sha256", sha256Sum)
+}
+
+func (r *remoteStore) blobIndexObject() string {
+	return r.stagedObject("blobs", "index.json")
+}
+
+func (r *remoteStore) readBlobIndex() (blobIndex, error) {
+	raw, err := r.kv.read(r.blobIndexObject())
+	if err != nil {
+		if _, ok := err.(ErrFileNotFound); ok {
+			return blobIndex{}, nil
+		}
+		return nil, err
+	}
+	idx := blobIndex{}
+	if err := json.Unmarshal(raw, &idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (r *remoteStore) GetMeta() (map[string]json.RawMessage, error) {
+	meta := make(map[string]json.RawMessage)
+	for _, name := range topLevelManifests {
+		raw, err := r.kv.read(r.stagedObject(name))
+		if err != nil {
+			raw, err = r.kv.read(r.repoObject(name))
+			if err != nil {
+				continue
+			}
+		}
+		meta[name] = raw
+	}
+	return meta, nil
+}
+
+func (r *remoteStore) SetMeta(name string, meta json.RawMessage) error {
+	return r.kv.write(r.stagedObject(name), bytes.NewReader(meta))
+}
+
+func (r *remoteStore) GetStagedTarget(p string) (io.ReadCloser, error) {
+	idx, err := r.readBlobIndex()
+	if err != nil {
+		return nil, err
+	}
+	key := r.stagedObject("targets", p)
+	if sha256Sum, ok := idx[p]; ok {
+		key = r.blobObject(sha256Sum)
+	}
+	raw, err := r.kv.read(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(raw)), nil
+}
+
+// AddTargetStream streams r straight into the backend without buffering
+// it in memory first. Since the final, content-addressed key isn't known
+// until r has been read in full, it's written to a provisional key and
+// then moved to its hashed name; path -> digest is recorded in the blob
+// index so the same content staged under multiple paths is only stored
+// once (each call still uploads its bytes to the provisional key).
+func (r *remoteStore) AddTargetStream(p string, rd io.Reader) (data.Hashes, int64, error) {
+	tmp := r.stagedObject("blobs", "tmp-"+newTxID())
+	ht := newHashTee(rd)
+	if err := r.kv.write(tmp, ht); err != nil {
+		r.kv.remove(tmp)
+		return nil, 0, err
+	}
+	hashes := ht.hashes()
+	sha256Sum := hashes["sha256"].String()
+	if err := r.kv.move(tmp, r.blobObject(sha256Sum)); err != nil {
+		r.kv.remove(tmp)
+		return nil, 0, err
+	}
+	idx, err := r.readBlobIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+	idx[p] = sha256Sum
+	raw, err := json.Marshal(idx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := r.kv.write(r.blobIndexObject(), bytes.NewReader(raw)); err != nil {
+		return nil, 0, err
+	}
+	return hashes, ht.n, nil
+}
+
+func (r *remoteStore) WalkStagedTargets(patterns []string, fn TargetsWalkFunc) error {
+	idx, err := r.readBlobIndex()
+	if err != nil {
+		return err
+	}
+	targetsPrefix := r.stagedObject("targets")
+	rels, err := r.kv.list(targetsPrefix)
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels {
+		if _, ok := idx[rel]; ok {
+			// AddTargetStream re-staged this path; that copy is
+			// newer and takes precedence, same as GetStagedTarget.
+			continue
+		}
+		matched, err := matchesAny(patterns, rel)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		raw, err := r.kv.read(path.Join(targetsPrefix, rel))
+		if err != nil {
+			return err
+		}
+		if err := fn(rel, ioutil.NopCloser(bytes.NewReader(raw))); err != nil {
+			return err
+		}
+	}
+	for p, sha256Sum := range idx {
+		matched, err := matchesAny(patterns, p)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+		raw, err := r.kv.read(r.blobObject(sha256Sum))
+		if err != nil {
+			return err
+		}
+		if err := fn(p, ioutil.NopCloser(bytes.NewReader(raw))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *remoteStore) Commit(meta map[string]json.RawMessage, consistentSnapshot bool, hashes map[string]data.Hashes) error {
+	stagedPrefix := r.stagedObject()
+	staged, err := r.kv.list(stagedPrefix)
+	if err != nil {
+		return err
+	}
+	for _, rel := range staged {
+		if strings.HasPrefix(rel, "blobs/") {
+			continue
+		}
+		src := path.Join(stagedPrefix, rel)
+		for _, dst := range commitCopyPaths(rel, consistentSnapshot, hashes[rel]) {
+			if err := r.kv.copy(src, r.repoObject(dst)); err != nil {
+				return err
+			}
+		}
+	}
+	idx, err := r.readBlobIndex()
+	if err != nil {
+		return err
+	}
+	for targetPath, sha256Sum := range idx {
+		rel := path.Join("targets", targetPath)
+		src := r.blobObject(sha256Sum)
+		for _, dst := range commitCopyPaths(rel, consistentSnapshot, hashes[rel]) {
+			if err := r.kv.copy(src, r.repoObject(dst)); err != nil {
+				return err
+			}
+		}
+	}
+	repoPrefix := r.repoObject()
+	repoObjects, err := r.kv.list(repoPrefix)
+	if err != nil {
+		return err
+	}
+	for _, rel := range repoObjects {
+		if commitNeedsRemoval(rel, consistentSnapshot, hashes) {
+			r.kv.remove(r.repoObject(rel))
+		}
+	}
+	return r.Clean()
+}
+
+func (r *remoteStore) GetKeys(role string) ([]*data.Key, error) {
+	names, err := r.kv.list(r.keysObject())
+	if err != nil {
+		return nil, err
+	}
+	var keys []*data.Key
+	for _, name := range names {
+		if !strings.HasPrefix(name, role) {
+			continue
+		}
+		raw, err := r.kv.read(r.keysObject(name))
+		if err != nil {
+			return nil, err
+		}
+		key := &data.Key{}
+		if err := json.Unmarshal(raw, key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *remoteStore) SaveKey(role string, key *data.Key) error {
+	raw, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.kv.write(r.keysObject(role+"-"+key.ID()+".json"), bytes.NewReader(append(raw, '\n')))
+}
+
+func (r *remoteStore) Clean() error {
+	stagedPrefix := r.stagedObject()
+	names, err := r.kv.list(stagedPrefix)
+	if err != nil {
+		return err
+	}
+	for _, rel := range names {
+		r.kv.remove(path.Join(stagedPrefix, rel))
+	}
+	return nil
+}
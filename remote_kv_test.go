@@ -0,0 +1,205 @@
+package tuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flynn/go-tuf/data"
+)
+
+// memKV is a fake remoteKV backed by an in-memory map, so remoteStore can be
+// exercised without a real ftp/sftp/s3/gcs backend. readErr and moveErr let
+// tests simulate the kind of transient backend failure a real network store
+// can return.
+type memKV struct {
+	mu      sync.Mutex
+	data    map[string][]byte
+	readErr map[string]error
+	moveErr error
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string][]byte)}
+}
+
+func (k *memKV) read(key string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err, ok := k.readErr[key]; ok {
+		return nil, err
+	}
+	raw, ok := k.data[key]
+	if !ok {
+		return nil, ErrFileNotFound{key}
+	}
+	return raw, nil
+}
+
+func (k *memKV) write(key string, r io.Reader) error {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.data[key] = raw
+	return nil
+}
+
+func (k *memKV) list(prefix string) ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var names []string
+	for key := range k.data {
+		if rel, ok := memKVRel(prefix, key); ok {
+			names = append(names, rel)
+		}
+	}
+	return names, nil
+}
+
+func (k *memKV) copy(src, dst string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	raw, ok := k.data[src]
+	if !ok {
+		return ErrFileNotFound{src}
+	}
+	k.data[dst] = raw
+	return nil
+}
+
+func (k *memKV) move(src, dst string) error {
+	if k.moveErr != nil {
+		return k.moveErr
+	}
+	if err := k.copy(src, dst); err != nil {
+		return err
+	}
+	k.remove(src)
+	return nil
+}
+
+func (k *memKV) remove(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.data, key)
+}
+
+func memKVRel(prefix, key string) (string, bool) {
+	if !strings.HasPrefix(key, prefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(key, prefix+"/"), true
+}
+
+func TestRemoteStoreAddTargetStreamRoundTrip(t *testing.T) {
+	store := &remoteStore{kv: newMemKV()}
+	content := []byte("hello, tuf")
+
+	hashes, n, err := store.AddTargetStream("foo/bar", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("got n=%d, want %d", n, len(content))
+	}
+	if _, ok := hashes["sha256"]; !ok {
+		t.Fatal("expected a sha256 hash")
+	}
+
+	rc, err := store.GetStagedTarget("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("got %q, want %q", got, content)
+	}
+}
+
+// TestRemoteStoreAddTargetStreamPropagatesMoveFailure is a regression test:
+// if the provisional-to-final move fails, AddTargetStream must fail and must
+// not record the path in the blob index, since the content was never
+// actually placed at its final, content-addressed key.
+func TestRemoteStoreAddTargetStreamPropagatesMoveFailure(t *testing.T) {
+	kv := newMemKV()
+	kv.moveErr = errors.New("simulated upload failure")
+	store := &remoteStore{kv: kv}
+
+	if _, _, err := store.AddTargetStream("foo/bar", bytes.NewReader([]byte("hello"))); err == nil {
+		t.Fatal("expected AddTargetStream to fail when the move to the final key fails")
+	}
+
+	idx, err := store.readBlobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx["foo/bar"]; ok {
+		t.Fatal("blob index must not record a path whose content was never successfully moved into place")
+	}
+}
+
+func TestRemoteStoreReadBlobIndexTreatsMissingIndexAsEmpty(t *testing.T) {
+	store := &remoteStore{kv: newMemKV()}
+	idx, err := store.readBlobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx) != 0 {
+		t.Fatalf("expected an empty index when none has been written, got %v", idx)
+	}
+}
+
+// TestRemoteStoreReadBlobIndexPropagatesReadErrors is a regression test: a
+// transient read failure (network, auth, permissions) must not be treated
+// the same as "no blob index has been written yet".
+func TestRemoteStoreReadBlobIndexPropagatesReadErrors(t *testing.T) {
+	kv := newMemKV()
+	store := &remoteStore{kv: kv}
+	boom := errors.New("simulated transient failure")
+	kv.readErr = map[string]error{store.blobIndexObject(): boom}
+
+	if _, err := store.readBlobIndex(); err != boom {
+		t.Fatalf("expected readBlobIndex to propagate the underlying error, got %v", err)
+	}
+}
+
+func TestRemoteStoreCommitRemovesStaleTargets(t *testing.T) {
+	store := &remoteStore{kv: newMemKV()}
+
+	if err := store.SetMeta("timestamp.json", json.RawMessage(`{"v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.kv.write(store.stagedObject("targets", "keep.txt"), bytes.NewReader([]byte("keep"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(nil, false, map[string]data.Hashes{"targets/keep.txt": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.kv.read(store.repoObject("targets", "keep.txt")); err != nil {
+		t.Fatalf("expected kept target in repository: %v", err)
+	}
+
+	// Re-commit without keep.txt in the hash set: it's no longer a
+	// current target, so Commit must remove it from the repository.
+	if err := store.SetMeta("timestamp.json", json.RawMessage(`{"v":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.kv.read(store.repoObject("targets", "keep.txt")); err == nil {
+		t.Fatal("expected stale target to have been removed from the repository")
+	}
+}
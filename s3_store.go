@@ -0,0 +1,92 @@
+package tuf
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3KV is a remoteKV backed by an S3 bucket, addressed by a
+// s3://bucket/prefix URL.
+type s3KV struct {
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+func newS3Store(u *url.URL, opts *RemoteStoreOptions) (LocalStore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &remoteStore{
+		kv: &s3KV{
+			svc:      s3.New(sess),
+			uploader: s3manager.NewUploader(sess),
+			bucket:   u.Host,
+		},
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (k *s3KV) read(key string) ([]byte, error) {
+	out, err := k.svc.GetObject(&s3.GetObjectInput{Bucket: &k.bucket, Key: &key})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrFileNotFound{key}
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (k *s3KV) write(key string, r io.Reader) error {
+	_, err := k.uploader.Upload(&s3manager.UploadInput{Bucket: &k.bucket, Key: &key, Body: r})
+	return err
+}
+
+func (k *s3KV) list(prefix string) ([]string, error) {
+	var keys []string
+	err := k.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: &k.bucket,
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(*obj.Key, prefix+"/"))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// copy uses S3's server-side CopyObject, so the bytes themselves are never
+// re-uploaded.
+func (k *s3KV) copy(src, dst string) error {
+	_, err := k.svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     &k.bucket,
+		CopySource: aws.String(path.Join(k.bucket, src)),
+		Key:        aws.String(dst),
+	})
+	return err
+}
+
+func (k *s3KV) move(src, dst string) error {
+	if err := k.copy(src, dst); err != nil {
+		return err
+	}
+	k.remove(src)
+	return nil
+}
+
+func (k *s3KV) remove(key string) {
+	k.svc.DeleteObject(&s3.DeleteObjectInput{Bucket: &k.bucket, Key: &key}) // TODO: log / handle error
+}
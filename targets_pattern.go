@@ -0,0 +1,40 @@
+package tuf
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// AddTargetsWithPattern is equivalent to AddTargets, but rather than
+// requiring every target path to be listed explicitly, it expands patterns
+// (doublestar globs such as "targets/**/*.tar.gz" or
+// "targets/linux-*/bin/*") against the staged targets tree and signs
+// whatever matches. This makes it practical to sign large trees without
+// enumerating every file.
+//
+// Patterns are given with a leading "targets/", matching how target paths
+// read everywhere else in the repo API, but WalkStagedTargets matches
+// relative to the targets root itself, so that prefix is stripped before
+// expanding each pattern.
+func (r *Repo) AddTargetsWithPattern(patterns []string, custom json.RawMessage) error {
+	stripped := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		stripped[i] = normalizeTargetPattern(pattern)
+	}
+	var paths []string
+	err := r.local.WalkStagedTargets(stripped, func(path string, _ io.ReadCloser) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return r.AddTargets(paths, custom)
+}
+
+// normalizeTargetPattern strips a pattern's leading "targets/", if any, so
+// it matches the targets-root-relative paths WalkStagedTargets walks.
+func normalizeTargetPattern(pattern string) string {
+	return strings.TrimPrefix(pattern, "targets/")
+}
@@ -0,0 +1,45 @@
+package tuf
+
+import (
+	"io"
+	"testing"
+)
+
+func TestNormalizeTargetPattern(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"targets/**/*.tar.gz", "**/*.tar.gz"},
+		{"targets/linux-*/bin/*", "linux-*/bin/*"},
+		{"*.tar.gz", "*.tar.gz"},
+	}
+	for _, c := range cases {
+		if got := normalizeTargetPattern(c.in); got != c.want {
+			t.Errorf("normalizeTargetPattern(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// TestWalkStagedTargetsWithNormalizedPattern is a regression test for
+// AddTargetsWithPattern's documented example patterns (which are written
+// with a leading "targets/", matching every other target path in the repo
+// API) never matching anything, since WalkStagedTargets matches relative to
+// the targets root itself.
+func TestWalkStagedTargetsWithNormalizedPattern(t *testing.T) {
+	store := MemoryStore(nil, map[string][]byte{
+		"linux-amd64/bin/app":     []byte("binary"),
+		"archives/release.tar.gz": []byte("tarball"),
+	})
+
+	for _, pattern := range []string{"targets/**/*.tar.gz", "targets/linux-*/bin/*"} {
+		var matched []string
+		err := store.WalkStagedTargets([]string{normalizeTargetPattern(pattern)}, func(path string, _ io.ReadCloser) error {
+			matched = append(matched, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matched) != 1 {
+			t.Fatalf("pattern %q: got %d matches, want 1 (%v)", pattern, len(matched), matched)
+		}
+	}
+}
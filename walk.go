@@ -0,0 +1,30 @@
+package tuf
+
+import (
+	"io"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// TargetsWalkFunc is called by WalkStagedTargets for every staged target
+// whose path matches one of the requested patterns. The path is relative
+// to the staged targets tree (e.g. "linux-amd64/bin/app"), and target is
+// closed automatically once fn returns.
+type TargetsWalkFunc func(path string, target io.ReadCloser) error
+
+// matchesAny reports whether path matches any of the given doublestar
+// patterns (e.g. "**/*.tar.gz", "linux-*/bin/*"), written relative to the
+// staged targets tree like path itself. It is shared by every LocalStore
+// implementation's WalkStagedTargets.
+func matchesAny(patterns []string, path string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := doublestar.Match(pattern, path)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}